@@ -0,0 +1,114 @@
+package apps
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	consulConnectLabel          = "consul.connect"
+	consulConnectUpstreamsLabel = "consul.connect.upstreams"
+	consulAliasesLabel          = "consul.aliases"
+)
+
+// App is a Marathon application definition, as much of it as the consul
+// package needs to translate its tasks into service registrations.
+type App struct {
+	ID     string
+	Labels map[string]string
+}
+
+// ConnectUpstream is a single upstream dependency declared for a Connect
+// sidecar, analogous to go-micro's consul registry `connect` upstreams.
+type ConnectUpstream struct {
+	DestinationName string
+	LocalBindPort   int
+}
+
+// RegistrationIntent is what a Task wants registered in Consul: a service
+// name plus the tags/flags derived from the App's labels.
+type RegistrationIntent struct {
+	Name      string
+	Port      int
+	Tags      []string
+	Connect   bool
+	Upstreams []ConnectUpstream
+	Alias     bool
+}
+
+// RegistrationIntents returns the registration(s) a task should produce in
+// Consul for this app: one for the app's own name, plus one additional
+// intent per alias declared with the consul.aliases label, similar to
+// fabio's register=name tag that lets a service publish itself under extra
+// names for host-specific routes. Aliases share the primary intent's
+// Connect configuration since they share the task's lifecycle.
+func (app *App) RegistrationIntents(task *Task, nameSeparator string) []RegistrationIntent {
+	name := strings.Trim(strings.Replace(app.ID, "/", nameSeparator, -1), nameSeparator)
+
+	port := 0
+	if len(task.Ports) > 0 {
+		port = task.Ports[0]
+	}
+
+	connect, upstreams := app.connectConfig()
+
+	intents := []RegistrationIntent{
+		{Name: name, Port: port, Connect: connect, Upstreams: upstreams},
+	}
+	for _, alias := range app.aliases() {
+		intents = append(intents, RegistrationIntent{
+			Name:      alias,
+			Port:      port,
+			Tags:      []string{fmt.Sprintf("urlprefix-%s/", alias)},
+			Connect:   connect,
+			Upstreams: upstreams,
+			Alias:     true,
+		})
+	}
+	return intents
+}
+
+// aliases parses the consul.aliases label, a comma-separated list of extra
+// service names (e.g. host-based routes) this app should also register
+// under, sharing the same task lifecycle as the primary registration.
+func (app *App) aliases() []string {
+	raw := app.Labels[consulAliasesLabel]
+	if raw == "" {
+		return nil
+	}
+	var aliases []string
+	for _, alias := range strings.Split(raw, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// connectConfig reports whether the app opted into a Connect sidecar via the
+// consul.connect=true label, and parses any declared upstreams from
+// consul.connect.upstreams (a comma-separated list of name:port pairs).
+func (app *App) connectConfig() (bool, []ConnectUpstream) {
+	if app.Labels[consulConnectLabel] != "true" {
+		return false, nil
+	}
+	var upstreams []ConnectUpstream
+	for _, decl := range strings.Split(app.Labels[consulConnectUpstreamsLabel], ",") {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		port, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		upstreams = append(upstreams, ConnectUpstream{DestinationName: parts[0], LocalBindPort: port})
+	}
+	return true, upstreams
+}
@@ -0,0 +1,99 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationIntents_PlainApp(t *testing.T) {
+	app := &App{ID: "/my/app", Labels: map[string]string{}}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	assert.Len(t, intents, 1)
+	assert.Equal(t, "my.app", intents[0].Name)
+	assert.Equal(t, 8080, intents[0].Port)
+	assert.False(t, intents[0].Connect)
+}
+
+func TestRegistrationIntents_ConsulConnectLabel(t *testing.T) {
+	app := &App{
+		ID: "/my/app",
+		Labels: map[string]string{
+			consulConnectLabel: "true",
+		},
+	}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	assert.Len(t, intents, 1)
+	assert.True(t, intents[0].Connect)
+	assert.Empty(t, intents[0].Upstreams)
+}
+
+func TestRegistrationIntents_ConsulConnectUpstreams(t *testing.T) {
+	app := &App{
+		ID: "/my/app",
+		Labels: map[string]string{
+			consulConnectLabel:          "true",
+			consulConnectUpstreamsLabel: "billing:9001, reporting:9002",
+		},
+	}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1"}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	assert.Equal(t, []ConnectUpstream{
+		{DestinationName: "billing", LocalBindPort: 9001},
+		{DestinationName: "reporting", LocalBindPort: 9002},
+	}, intents[0].Upstreams)
+}
+
+func TestRegistrationIntents_ConnectNotRequestedIgnoresUpstreams(t *testing.T) {
+	app := &App{
+		ID: "/my/app",
+		Labels: map[string]string{
+			consulConnectUpstreamsLabel: "billing:9001",
+		},
+	}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1"}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	assert.False(t, intents[0].Connect)
+	assert.Nil(t, intents[0].Upstreams)
+}
+
+func TestRegistrationIntents_AliasesLabelAddsExtraIntents(t *testing.T) {
+	app := &App{
+		ID: "/my/app",
+		Labels: map[string]string{
+			consulAliasesLabel: "app-alias, other-alias",
+		},
+	}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	require.Len(t, intents, 3)
+	assert.False(t, intents[0].Alias)
+	assert.Equal(t, "my.app", intents[0].Name)
+	assert.True(t, intents[1].Alias)
+	assert.Equal(t, "app-alias", intents[1].Name)
+	assert.Equal(t, []string{"urlprefix-app-alias/"}, intents[1].Tags)
+	assert.True(t, intents[2].Alias)
+	assert.Equal(t, "other-alias", intents[2].Name)
+}
+
+func TestRegistrationIntents_NoAliasesLabelAddsNoExtraIntents(t *testing.T) {
+	app := &App{ID: "/my/app", Labels: map[string]string{}}
+	task := &Task{ID: "my-app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+
+	intents := app.RegistrationIntents(task, ".")
+
+	assert.Len(t, intents, 1)
+}
@@ -0,0 +1,15 @@
+package apps
+
+// TaskID is a Marathon task identifier, e.g. "myapp.instance-deadbeef".
+type TaskID string
+
+func (id TaskID) String() string {
+	return string(id)
+}
+
+// Task is a single running instance of a Marathon App.
+type Task struct {
+	ID    TaskID
+	Host  string
+	Ports []int
+}
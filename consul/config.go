@@ -0,0 +1,18 @@
+package consul
+
+// Config configures how marathon-consul talks to the local Consul agent and
+// how it names the services it registers.
+type Config struct {
+	Tag                 string
+	ConsulNameSeparator string
+
+	// ConsulNamePrefix namespaces every registered service name, e.g. so
+	// multiple environments can share a Consul cluster without name
+	// collisions.
+	ConsulNamePrefix string
+
+	// ConsulNameMigration, when set, makes GetServices also match the
+	// un-prefixed name during a rollout window, so consumers still looking
+	// up the old name keep working while ConsulNamePrefix is adopted.
+	ConsulNameMigration bool
+}
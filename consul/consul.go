@@ -0,0 +1,268 @@
+package consul
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Consul is the production service.Registry backend, backed by a real
+// Consul agent.
+type Consul struct {
+	sync.RWMutex
+	config            Config
+	client            *consulapi.Client
+	serviceIDsByTask  map[apps.TaskID][]service.ID
+	taskIDByServiceID map[service.ID]apps.TaskID
+}
+
+var _ service.Registry = (*Consul)(nil)
+
+// New constructs a Consul client for the given config.
+func New(config Config) *Consul {
+	return &Consul{
+		config:            config,
+		serviceIDsByTask:  make(map[apps.TaskID][]service.ID),
+		taskIDByServiceID: make(map[service.ID]apps.TaskID),
+	}
+}
+
+// agent lazily dials the local Consul agent so a Config can be built (e.g.
+// by the Stub) without one running.
+func (c *Consul) agent() (*consulapi.Agent, error) {
+	if c.client == nil {
+		client, err := consulapi.NewClient(consulapi.DefaultConfig())
+		if err != nil {
+			return nil, fmt.Errorf("unable to create Consul client: %s", err)
+		}
+		c.client = client
+	}
+	return c.client.Agent(), nil
+}
+
+func (c *Consul) Register(task *apps.Task, app *apps.App) error {
+	agent, err := c.agent()
+	if err != nil {
+		return err
+	}
+	registrations, err := c.marathonTaskToConsulServices(task, app)
+	if err != nil {
+		return err
+	}
+	for _, registration := range registrations {
+		if err := agent.ServiceRegister(registration); err != nil {
+			return fmt.Errorf("unable to register service %s for task %s: %s", registration.Name, task.ID, err)
+		}
+		c.track(task.ID, service.ID(registration.ID))
+	}
+	return nil
+}
+
+func (c *Consul) Deregister(toDeregister *service.Service) error {
+	agent, err := c.agent()
+	if err != nil {
+		return err
+	}
+	if err := agent.ServiceDeregister(string(toDeregister.ID)); err != nil {
+		return fmt.Errorf("unable to deregister service %s: %s", toDeregister.ID, err)
+	}
+	c.untrack(toDeregister.ID)
+	return nil
+}
+
+func (c *Consul) DeregisterByTask(taskID apps.TaskID) error {
+	agent, err := c.agent()
+	if err != nil {
+		return err
+	}
+	for _, id := range c.ServicesForTask(taskID) {
+		if err := agent.ServiceDeregister(string(id)); err != nil {
+			return fmt.Errorf("unable to deregister task %s service %s: %s", taskID, id, err)
+		}
+		c.untrack(id)
+	}
+	return nil
+}
+
+// ServicesForTask returns the IDs of the services registered for taskID,
+// tracked on Register and dropped on Deregister/DeregisterByTask. This
+// makes deregistration O(1) and doesn't depend on the marathon-task tag
+// string, which breaks if a user renames that tag.
+func (c *Consul) ServicesForTask(taskID apps.TaskID) []service.ID {
+	c.RLock()
+	defer c.RUnlock()
+	ids := make([]service.ID, len(c.serviceIDsByTask[taskID]))
+	copy(ids, c.serviceIDsByTask[taskID])
+	return ids
+}
+
+// track records that id belongs to taskID. Marathon-consul re-registers
+// running tasks periodically, so a taskID/id pair already tracked is a
+// no-op rather than a duplicate append; a ID that moved to a different
+// task (shouldn't normally happen) is re-parented instead of left stale
+// under the old one.
+func (c *Consul) track(taskID apps.TaskID, id service.ID) {
+	c.Lock()
+	defer c.Unlock()
+	if existingTaskID, ok := c.taskIDByServiceID[id]; ok {
+		if existingTaskID == taskID {
+			return
+		}
+		c.untrackLocked(id)
+	}
+	c.serviceIDsByTask[taskID] = append(c.serviceIDsByTask[taskID], id)
+	c.taskIDByServiceID[id] = taskID
+}
+
+func (c *Consul) untrack(id service.ID) {
+	c.Lock()
+	defer c.Unlock()
+	c.untrackLocked(id)
+}
+
+func (c *Consul) untrackLocked(id service.ID) {
+	taskID, ok := c.taskIDByServiceID[id]
+	if !ok {
+		return
+	}
+	delete(c.taskIDByServiceID, id)
+	ids := c.serviceIDsByTask[taskID]
+	for i, existing := range ids {
+		if existing == id {
+			c.serviceIDsByTask[taskID] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(c.serviceIDsByTask[taskID]) == 0 {
+		delete(c.serviceIDsByTask, taskID)
+	}
+}
+
+func (c *Consul) GetServices(name string) ([]*service.Service, error) {
+	services, err := c.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	var matching []*service.Service
+	for _, s := range services {
+		if c.matchesName(s.Name, name) && contains(s.Tags, c.config.Tag) {
+			matching = append(matching, s)
+		}
+	}
+	return matching, nil
+}
+
+// matchesName reports whether a stored, namespaced service name satisfies a
+// lookup for the un-prefixed name. During a ConsulNameMigration rollout
+// window services may still be registered under the bare name, so both
+// forms are accepted.
+func (c *Consul) matchesName(storedName, name string) bool {
+	if storedName == c.config.ConsulNamePrefix+name {
+		return true
+	}
+	return c.config.ConsulNameMigration && storedName == name
+}
+
+func (c *Consul) GetAllServices() ([]*service.Service, error) {
+	agent, err := c.agent()
+	if err != nil {
+		return nil, err
+	}
+	agentServices, err := agent.Services()
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch services from Consul agent: %s", err)
+	}
+	var services []*service.Service
+	for _, s := range agentServices {
+		services = append(services, &service.Service{
+			ID:           service.ID(s.ID),
+			Name:         s.Service,
+			Tags:         s.Tags,
+			AgentAddress: s.Address,
+			Connect:      s.Connect != nil,
+		})
+	}
+	return services, nil
+}
+
+// marathonTaskToConsulServices translates a task, together with the
+// registration intents declared by its app's labels, into the
+// AgentServiceRegistrations that should be sent to the local agent. A
+// consul.connect=true label attaches a Connect sidecar registration,
+// mirroring how go-micro's consul registry exposes a connect flag.
+func (c *Consul) marathonTaskToConsulServices(task *apps.Task, app *apps.App) ([]*consulapi.AgentServiceRegistration, error) {
+	intents := app.RegistrationIntents(task, c.config.ConsulNameSeparator)
+
+	registrations := make([]*consulapi.AgentServiceRegistration, 0, len(intents))
+	for _, intent := range intents {
+		registration := &consulapi.AgentServiceRegistration{
+			ID:      registrationID(task, intent),
+			Name:    c.namespacedName(intent),
+			Port:    intent.Port,
+			Address: task.Host,
+			Tags:    append(append([]string{}, intent.Tags...), c.config.Tag, fmt.Sprintf("marathon-task:%s", task.ID)),
+			Checks:  consulapi.AgentServiceChecks{},
+		}
+		if intent.Connect {
+			registration.Connect = &consulapi.AgentServiceConnect{
+				SidecarService: &consulapi.AgentServiceRegistration{
+					Port:  intent.Port,
+					Proxy: connectProxyConfig(intent.Upstreams),
+				},
+			}
+		}
+		registrations = append(registrations, registration)
+	}
+	return registrations, nil
+}
+
+// namespacedName applies ConsulNamePrefix to an intent's name. Aliases are
+// host-based routes declared verbatim via the consul.aliases label, so
+// unlike the primary name they're deliberately left un-prefixed.
+func (c *Consul) namespacedName(intent apps.RegistrationIntent) string {
+	if intent.Alias {
+		return intent.Name
+	}
+	return c.config.ConsulNamePrefix + intent.Name
+}
+
+// registrationID picks the AgentServiceRegistration ID for an intent. The
+// primary intent keeps the task ID itself so existing lookups by task ID
+// keep working; aliases get a derived, still task-scoped ID so they don't
+// collide with it or with each other in the services map, and so
+// DeregisterByTask/ServicesForTask removes them atomically with the rest of
+// the task's registrations.
+func registrationID(task *apps.Task, intent apps.RegistrationIntent) string {
+	if !intent.Alias {
+		return task.ID.String()
+	}
+	return fmt.Sprintf("%s-%s", task.ID, intent.Name)
+}
+
+func connectProxyConfig(upstreams []apps.ConnectUpstream) *consulapi.AgentServiceConnectProxyConfig {
+	if len(upstreams) == 0 {
+		return nil
+	}
+	proxy := &consulapi.AgentServiceConnectProxyConfig{
+		Upstreams: make([]consulapi.Upstream, 0, len(upstreams)),
+	}
+	for _, u := range upstreams {
+		proxy.Upstreams = append(proxy.Upstreams, consulapi.Upstream{
+			DestinationName: u.DestinationName,
+			LocalBindPort:   u.LocalBindPort,
+		})
+	}
+	return proxy
+}
+
+func contains(list []string, x string) bool {
+	for _, s := range list {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
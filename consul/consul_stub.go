@@ -9,10 +9,17 @@ import (
 	consulapi "github.com/hashicorp/consul/api"
 )
 
+// Stub implements service.Registry so it can stand in for any concrete
+// backend (Consul, or an alternative registry) in tests of the sync loop,
+// event handler and health checks, which depend on the interface only.
+var _ service.Registry = (*Stub)(nil)
+
 // TODO this should be a service registry stub in the service package, requires abstracting from AgentServiceRegistration
 type Stub struct {
 	sync.RWMutex
 	services                   map[service.ID]*consulapi.AgentServiceRegistration
+	serviceIDsByTask           map[apps.TaskID][]service.ID
+	taskIDByServiceID          map[service.ID]apps.TaskID
 	failGetServicesForNames    map[string]bool
 	failRegisterForIDs         map[apps.TaskID]bool
 	failDeregisterByTaskForIDs map[apps.TaskID]bool
@@ -25,13 +32,22 @@ func NewConsulStub() *Stub {
 }
 
 func NewConsulStubWithTag(tag string) *Stub {
+	return NewConsulStubWithTagAndPrefix(tag, "")
+}
+
+// NewConsulStubWithTagAndPrefix mirrors NewConsulStubWithTag but additionally
+// namespaces every registered service name with prefix, as ConsulNamePrefix
+// does on the real client.
+func NewConsulStubWithTagAndPrefix(tag, prefix string) *Stub {
 	return &Stub{
 		services:                   make(map[service.ID]*consulapi.AgentServiceRegistration),
+		serviceIDsByTask:           make(map[apps.TaskID][]service.ID),
+		taskIDByServiceID:          make(map[service.ID]apps.TaskID),
 		failGetServicesForNames:    make(map[string]bool),
 		failRegisterForIDs:         make(map[apps.TaskID]bool),
 		failDeregisterByTaskForIDs: make(map[apps.TaskID]bool),
 		failDeregisterForIDs:       make(map[service.ID]bool),
-		consul:                     New(Config{Tag: tag, ConsulNameSeparator: "."}),
+		consul:                     New(Config{Tag: tag, ConsulNameSeparator: ".", ConsulNamePrefix: prefix}),
 	}
 }
 
@@ -45,6 +61,7 @@ func (c *Stub) GetAllServices() ([]*service.Service, error) {
 			Name:         s.Name,
 			Tags:         s.Tags,
 			AgentAddress: s.Address,
+			Connect:      s.Connect != nil,
 		})
 	}
 	return allServices, nil
@@ -74,12 +91,13 @@ func (c *Stub) GetServices(name string) ([]*service.Service, error) {
 	}
 	var services []*service.Service
 	for _, s := range c.services {
-		if s.Name == name && contains(s.Tags, c.consul.config.Tag) {
+		if c.consul.matchesName(s.Name, name) && contains(s.Tags, c.consul.config.Tag) {
 			services = append(services, &service.Service{
 				ID:           service.ID(s.ID),
 				Name:         s.Name,
 				Tags:         s.Tags,
 				AgentAddress: s.Address,
+				Connect:      s.Connect != nil,
 			})
 		}
 	}
@@ -97,6 +115,7 @@ func (c *Stub) Register(task *apps.Task, app *apps.App) error {
 		return err
 	}
 	for _, r := range serviceRegistrations {
+		c.track(task.ID, service.ID(r.ID))
 		c.services[service.ID(r.ID)] = r
 	}
 	return nil
@@ -107,13 +126,22 @@ func (c *Stub) RegisterWithoutMarathonTaskTag(task *apps.Task, app *apps.App) {
 	defer c.Unlock()
 	for _, intent := range app.RegistrationIntents(task, c.consul.config.ConsulNameSeparator) {
 		serviceRegistration := consulapi.AgentServiceRegistration{
-			ID:      task.ID.String(),
-			Name:    intent.Name,
+			ID:      registrationID(task, intent),
+			Name:    c.consul.namespacedName(intent),
 			Port:    intent.Port,
 			Address: task.Host,
 			Tags:    intent.Tags,
 			Checks:  consulapi.AgentServiceChecks{},
 		}
+		if intent.Connect {
+			serviceRegistration.Connect = &consulapi.AgentServiceConnect{
+				SidecarService: &consulapi.AgentServiceRegistration{
+					Port:  intent.Port,
+					Proxy: connectProxyConfig(intent.Upstreams),
+				},
+			}
+		}
+		c.track(task.ID, service.ID(serviceRegistration.ID))
 		c.services[service.ID(serviceRegistration.ID)] = &serviceRegistration
 	}
 }
@@ -122,6 +150,7 @@ func (c *Stub) RegisterOnlyFirstRegistrationIntent(task *apps.Task, app *apps.Ap
 	c.Lock()
 	defer c.Unlock()
 	serviceRegistrations, _ := c.consul.marathonTaskToConsulServices(task, app)
+	c.track(task.ID, service.ID(serviceRegistrations[0].ID))
 	c.services[service.ID(serviceRegistrations[0].ID)] = serviceRegistrations[0]
 }
 
@@ -131,9 +160,11 @@ func (c *Stub) DeregisterByTask(taskID apps.TaskID) error {
 	if _, ok := c.failDeregisterByTaskForIDs[taskID]; ok {
 		return fmt.Errorf("Consul stub programmed to fail when deregistering task of id %s", taskID.String())
 	}
-	for _, x := range c.servicesMatchingTask(taskID) {
-		delete(c.services, service.ID(x.ID))
+	for _, id := range c.serviceIDsByTask[taskID] {
+		delete(c.services, id)
+		delete(c.taskIDByServiceID, id)
 	}
+	delete(c.serviceIDsByTask, taskID)
 	return nil
 }
 
@@ -143,18 +174,68 @@ func (c *Stub) Deregister(toDeregister *service.Service) error {
 	if _, ok := c.failDeregisterForIDs[toDeregister.ID]; ok {
 		return fmt.Errorf("Consul stub programmed to fail when deregistering service of id %s", toDeregister.ID)
 	}
+	c.untrack(toDeregister.ID)
 	delete(c.services, toDeregister.ID)
 	return nil
 }
 
-func (c *Stub) servicesMatchingTask(taskID apps.TaskID) []*consulapi.AgentServiceRegistration {
-	matching := []*consulapi.AgentServiceRegistration{}
-	for _, s := range c.services {
-		if s.ID == taskID.String() || contains(s.Tags, fmt.Sprintf("marathon-task:%s", taskID.String())) {
-			matching = append(matching, s)
+// ServicesForTask returns the IDs of the services registered for taskID,
+// tracked on Register and dropped on Deregister/DeregisterByTask, so callers
+// can diff intended vs actual registrations without scanning the agent.
+func (c *Stub) ServicesForTask(taskID apps.TaskID) []service.ID {
+	c.RLock()
+	defer c.RUnlock()
+	ids := make([]service.ID, len(c.serviceIDsByTask[taskID]))
+	copy(ids, c.serviceIDsByTask[taskID])
+	return ids
+}
+
+// RegisteredServiceNames returns the service names registered for taskID,
+// one per alias (see apps.RegistrationIntent) in addition to the app's
+// primary name, so tests can assert a task's aliases were all registered
+// under the same task lifecycle.
+func (c *Stub) RegisteredServiceNames(taskID apps.TaskID) []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.serviceIDsByTask[taskID]))
+	for _, id := range c.serviceIDsByTask[taskID] {
+		if s, ok := c.services[id]; ok {
+			names = append(names, s.Name)
 		}
 	}
-	return matching
+	return names
+}
+
+// track records that id belongs to taskID. Marathon-consul re-registers
+// running tasks periodically, so a taskID/id pair already tracked is a
+// no-op rather than a duplicate append.
+func (c *Stub) track(taskID apps.TaskID, id service.ID) {
+	if existingTaskID, ok := c.taskIDByServiceID[id]; ok {
+		if existingTaskID == taskID {
+			return
+		}
+		c.untrack(id)
+	}
+	c.serviceIDsByTask[taskID] = append(c.serviceIDsByTask[taskID], id)
+	c.taskIDByServiceID[id] = taskID
+}
+
+func (c *Stub) untrack(id service.ID) {
+	taskID, ok := c.taskIDByServiceID[id]
+	if !ok {
+		return
+	}
+	delete(c.taskIDByServiceID, id)
+	ids := c.serviceIDsByTask[taskID]
+	for i, existing := range ids {
+		if existing == id {
+			c.serviceIDsByTask[taskID] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(c.serviceIDsByTask[taskID]) == 0 {
+		delete(c.serviceIDsByTask, taskID)
+	}
 }
 
 func (c *Stub) RegisteredTaskIDs(serviceName string) []apps.TaskID {
@@ -166,3 +247,13 @@ func (c *Stub) RegisteredTaskIDs(serviceName string) []apps.TaskID {
 	}
 	return taskIds
 }
+
+// RegisteredAgentServiceRegistration exposes the raw registration stored for a
+// service ID, Connect sidecar included. service.Service has no notion of
+// Connect, so tests asserting sidecar registrations need the untranslated
+// consulapi.AgentServiceRegistration the stub keeps internally.
+func (c *Stub) RegisteredAgentServiceRegistration(id service.ID) *consulapi.AgentServiceRegistration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.services[id]
+}
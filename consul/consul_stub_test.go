@@ -0,0 +1,118 @@
+package consul
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStub_ConnectLabelAttachesSidecarRegistration(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.connect": "true"}}
+
+	require.NoError(t, stub.Register(task, app))
+
+	registration := stub.RegisteredAgentServiceRegistration(service.ID(task.ID.String()))
+	require.NotNil(t, registration)
+	require.NotNil(t, registration.Connect)
+	require.NotNil(t, registration.Connect.SidecarService)
+	assert.Equal(t, 8080, registration.Connect.SidecarService.Port)
+}
+
+func TestStub_RegisterWithoutMarathonTaskTagAttachesSidecarRegistration(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.connect": "true"}}
+
+	stub.RegisterWithoutMarathonTaskTag(task, app)
+
+	registration := stub.RegisteredAgentServiceRegistration(service.ID(task.ID.String()))
+	require.NotNil(t, registration)
+	require.NotNil(t, registration.Connect)
+	require.NotNil(t, registration.Connect.SidecarService)
+}
+
+func TestStub_ServicesForTaskTracksRegistrationsAndDedupsOnReregister(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app"}
+
+	require.NoError(t, stub.Register(task, app))
+	require.NoError(t, stub.Register(task, app))
+
+	assert.Len(t, stub.ServicesForTask(task.ID), 1)
+}
+
+func TestStub_RegisterWithoutMarathonTaskTagWithAliasesRegistersEachDistinctly(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.aliases": "app-alias"}}
+
+	stub.RegisterWithoutMarathonTaskTag(task, app)
+
+	assert.ElementsMatch(t, []string{"app", "app-alias"}, stub.RegisteredServiceNames(task.ID))
+}
+
+func TestStub_RegisterWithAliasesRegistersOneServicePerAlias(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.aliases": "app-alias, other-alias"}}
+
+	require.NoError(t, stub.Register(task, app))
+
+	assert.ElementsMatch(t, []string{"app", "app-alias", "other-alias"}, stub.RegisteredServiceNames(task.ID))
+}
+
+func TestStub_DeregisterByTaskRemovesAllAliasesAtomically(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.aliases": "app-alias"}}
+	require.NoError(t, stub.Register(task, app))
+
+	require.NoError(t, stub.DeregisterByTask(task.ID))
+
+	assert.Empty(t, stub.RegisteredServiceNames(task.ID))
+}
+
+func TestStub_ConsulNamePrefixNamespacesPrimaryButNotAliases(t *testing.T) {
+	stub := NewConsulStubWithTagAndPrefix("marathon", "env-")
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app", Labels: map[string]string{"consul.aliases": "app-alias"}}
+
+	require.NoError(t, stub.Register(task, app))
+
+	assert.ElementsMatch(t, []string{"env-app", "app-alias"}, stub.RegisteredServiceNames(task.ID))
+}
+
+func TestStub_ConsulNameMigrationMatchesUnprefixedName(t *testing.T) {
+	stub := NewConsulStubWithTagAndPrefix("marathon", "env-")
+	stub.services["legacy"] = &consulapi.AgentServiceRegistration{ID: "legacy", Name: "app", Tags: []string{"marathon"}}
+
+	services, err := stub.GetServices("app")
+	require.NoError(t, err)
+	assert.Empty(t, services)
+
+	stub.consul.config.ConsulNameMigration = true
+	services, err = stub.GetServices("app")
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+}
+
+func TestStub_DeregisterUntracksSingleService(t *testing.T) {
+	stub := NewConsulStub()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app"}
+	require.NoError(t, stub.Register(task, app))
+	services, err := stub.GetServices("app")
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+
+	require.NoError(t, stub.Deregister(services[0]))
+
+	assert.Empty(t, stub.ServicesForTask(task.ID))
+}
@@ -0,0 +1,47 @@
+package consul
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsul_TrackDedupsOnReregister(t *testing.T) {
+	c := New(Config{Tag: "marathon", ConsulNameSeparator: "."})
+	taskID := apps.TaskID("app.task-1")
+
+	c.track(taskID, "svc-1")
+	c.track(taskID, "svc-1")
+
+	assert.Len(t, c.ServicesForTask(taskID), 1)
+}
+
+func TestConsul_UntrackRemovesOnlyTheGivenService(t *testing.T) {
+	c := New(Config{Tag: "marathon", ConsulNameSeparator: "."})
+	taskID := apps.TaskID("app.task-1")
+	c.track(taskID, "svc-1")
+	c.track(taskID, "svc-2")
+
+	c.untrack("svc-1")
+
+	assert.ElementsMatch(t, []service.ID{"svc-2"}, c.ServicesForTask(taskID))
+}
+
+func TestConsul_NamespacedNamePrefixesPrimaryButNotAliases(t *testing.T) {
+	c := New(Config{Tag: "marathon", ConsulNameSeparator: ".", ConsulNamePrefix: "env-"})
+
+	assert.Equal(t, "env-app", c.namespacedName(apps.RegistrationIntent{Name: "app"}))
+	assert.Equal(t, "app-alias", c.namespacedName(apps.RegistrationIntent{Name: "app-alias", Alias: true}))
+}
+
+func TestConsul_MatchesNameAcceptsUnprefixedDuringMigration(t *testing.T) {
+	migrating := New(Config{Tag: "marathon", ConsulNameSeparator: ".", ConsulNamePrefix: "env-", ConsulNameMigration: true})
+	assert.True(t, migrating.matchesName("env-app", "app"))
+	assert.True(t, migrating.matchesName("app", "app"))
+
+	notMigrating := New(Config{Tag: "marathon", ConsulNameSeparator: ".", ConsulNamePrefix: "env-"})
+	assert.True(t, notMigrating.matchesName("env-app", "app"))
+	assert.False(t, notMigrating.matchesName("app", "app"))
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/allegro/marathon-consul/apps"
+)
+
+// MemoryRegistry is a dependency-free Registry backend that keeps services
+// in process memory instead of a Consul agent. It's the alternative backend
+// selectable alongside consul.Consul, mirroring go-micro/nomad's registry
+// abstraction (consul, etcd, mdns, memory); it's also handy as a Registry
+// for tests that don't want to depend on the consul package at all.
+type MemoryRegistry struct {
+	sync.RWMutex
+	services map[ID]*Service
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{services: make(map[ID]*Service)}
+}
+
+var _ Registry = (*MemoryRegistry)(nil)
+
+func (m *MemoryRegistry) Register(task *apps.Task, app *apps.App) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, intent := range app.RegistrationIntents(task, ".") {
+		id := ID(fmt.Sprintf("%s-%s", task.ID, intent.Name))
+		m.services[id] = &Service{
+			ID:           id,
+			Name:         intent.Name,
+			Tags:         append(append([]string{}, intent.Tags...), fmt.Sprintf("%s%s", taskTagPrefix, task.ID)),
+			AgentAddress: task.Host,
+			Connect:      intent.Connect,
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRegistry) Deregister(toDeregister *Service) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.services, toDeregister.ID)
+	return nil
+}
+
+func (m *MemoryRegistry) DeregisterByTask(taskID apps.TaskID) error {
+	m.Lock()
+	defer m.Unlock()
+	for id, s := range m.services {
+		if registeredTaskID, err := s.TaskID(); err == nil && registeredTaskID == taskID {
+			delete(m.services, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRegistry) GetServices(name string) ([]*Service, error) {
+	m.RLock()
+	defer m.RUnlock()
+	var services []*Service
+	for _, s := range m.services {
+		if s.Name == name {
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
+
+func (m *MemoryRegistry) GetAllServices() ([]*Service, error) {
+	m.RLock()
+	defer m.RUnlock()
+	var all []*Service
+	for _, s := range m.services {
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+func (m *MemoryRegistry) ServicesForTask(taskID apps.TaskID) []ID {
+	m.RLock()
+	defer m.RUnlock()
+	var ids []ID
+	for id, s := range m.services {
+		if registeredTaskID, err := s.TaskID(); err == nil && registeredTaskID == taskID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRegistry_ImplementsRegistry(t *testing.T) {
+	var _ Registry = NewMemoryRegistry()
+}
+
+func TestMemoryRegistry_RegisterAndGetServices(t *testing.T) {
+	registry := NewMemoryRegistry()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app"}
+
+	require.NoError(t, registry.Register(task, app))
+
+	services, err := registry.GetServices("app")
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, "10.0.0.1", services[0].AgentAddress)
+}
+
+func TestMemoryRegistry_DeregisterByTaskRemovesAllOfATasksServices(t *testing.T) {
+	registry := NewMemoryRegistry()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app"}
+	require.NoError(t, registry.Register(task, app))
+
+	require.NoError(t, registry.DeregisterByTask(task.ID))
+
+	all, err := registry.GetAllServices()
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestMemoryRegistry_ServicesForTaskReturnsTheTasksServiceID(t *testing.T) {
+	registry := NewMemoryRegistry()
+	task := &apps.Task{ID: "app.task-1", Host: "10.0.0.1", Ports: []int{8080}}
+	app := &apps.App{ID: "/app"}
+	require.NoError(t, registry.Register(task, app))
+
+	ids := registry.ServicesForTask(task.ID)
+
+	require.Len(t, ids, 1)
+	assert.Equal(t, ID("app.task-1-app"), ids[0])
+}
@@ -0,0 +1,17 @@
+package service
+
+import "github.com/allegro/marathon-consul/apps"
+
+// Registry abstracts the concrete service-discovery backend (Consul, or an
+// alternative implementation) so the sync loop, event handler and health
+// checks can depend on behaviour rather than a specific client, mirroring
+// the go-micro/nomad pattern of a Registry interface in front of consulApi,
+// etcd, mdns or an in-memory backend.
+type Registry interface {
+	Register(task *apps.Task, app *apps.App) error
+	Deregister(toDeregister *Service) error
+	DeregisterByTask(taskID apps.TaskID) error
+	GetServices(name string) ([]*Service, error)
+	GetAllServices() ([]*Service, error)
+	ServicesForTask(taskID apps.TaskID) []ID
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/allegro/marathon-consul/apps"
+)
+
+// ID is a registered service's ID, as assigned by the registry backend.
+type ID string
+
+// Service is the registry-agnostic representation of a single registered
+// service instance.
+type Service struct {
+	ID           ID
+	Name         string
+	Tags         []string
+	AgentAddress string
+	Connect      bool
+}
+
+const taskTagPrefix = "marathon-task:"
+
+// TaskID recovers the Marathon task that registered this service from its
+// marathon-task:<id> tag.
+func (s *Service) TaskID() (apps.TaskID, error) {
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag, taskTagPrefix) {
+			return apps.TaskID(strings.TrimPrefix(tag, taskTagPrefix)), nil
+		}
+	}
+	return "", fmt.Errorf("service %s has no %s tag", s.ID, taskTagPrefix)
+}
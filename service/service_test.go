@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_TaskID(t *testing.T) {
+	s := &Service{ID: "app.task-1", Tags: []string{"marathon", "marathon-task:app.task-1"}}
+
+	taskID, err := s.TaskID()
+
+	assert.NoError(t, err)
+	assert.Equal(t, apps.TaskID("app.task-1"), taskID)
+}
+
+func TestService_TaskID_MissingTag(t *testing.T) {
+	s := &Service{ID: "app.task-1", Tags: []string{"marathon"}}
+
+	_, err := s.TaskID()
+
+	assert.Error(t, err)
+}